@@ -0,0 +1,517 @@
+package measured_http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// delegator is satisfied by responseWriterDelegator and by every combination
+// generated in pickDelegator below. It is the common type newDelegator
+// returns, regardless of which optional interfaces the wrapped
+// http.ResponseWriter happens to support.
+type delegator interface {
+	http.ResponseWriter
+	// Status returns the HTTP status code recorded for the response, or 0 if
+	// neither WriteHeader nor a body write has happened yet.
+	Status() int
+	// Size returns the number of response body bytes written so far.
+	Size() int64
+}
+
+// responseWriterDelegator wraps a http.ResponseWriter, recording the status
+// code written (defaulting to 200 if Write is called without a prior
+// WriteHeader) and the number of body bytes written, so both can be reported
+// as metrics.
+type responseWriterDelegator struct {
+	http.ResponseWriter
+	code int
+	size int64
+}
+
+func (d *responseWriterDelegator) WriteHeader(code int) {
+	d.code = code
+	d.ResponseWriter.WriteHeader(code)
+}
+
+func (d *responseWriterDelegator) Write(b []byte) (int, error) {
+	if d.code == 0 {
+		d.code = http.StatusOK
+	}
+	n, err := d.ResponseWriter.Write(b)
+	d.size += int64(n)
+	return n, err
+}
+
+func (d *responseWriterDelegator) Status() int {
+	return d.code
+}
+
+func (d *responseWriterDelegator) Size() int64 {
+	return d.size
+}
+
+// The following types each implement exactly one of the optional
+// http.ResponseWriter interfaces by delegating to the wrapped
+// http.ResponseWriter. They're combined by pickDelegator into one of the 32
+// structs needed to cover every subset of {Flusher, Hijacker, CloseNotifier,
+// Pusher, ReaderFrom} an underlying ResponseWriter might implement, following
+// the same approach used by Prometheus's promhttp package.
+type (
+	closeNotifierDelegator struct{ *responseWriterDelegator }
+	flusherDelegator       struct{ *responseWriterDelegator }
+	hijackerDelegator      struct{ *responseWriterDelegator }
+	pusherDelegator        struct{ *responseWriterDelegator }
+	readerFromDelegator    struct{ *responseWriterDelegator }
+)
+
+func (d closeNotifierDelegator) CloseNotify() <-chan bool {
+	//nolint:staticcheck // http.CloseNotifier is deprecated but still implemented by some ResponseWriters.
+	return d.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (d flusherDelegator) Flush() {
+	d.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (d hijackerDelegator) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return d.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (d pusherDelegator) Push(target string, opts *http.PushOptions) error {
+	return d.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+func (d readerFromDelegator) ReadFrom(re io.Reader) (int64, error) {
+	n, err := d.ResponseWriter.(io.ReaderFrom).ReadFrom(re)
+	if d.code == 0 {
+		d.code = http.StatusOK
+	}
+	d.size += n
+	return n, err
+}
+
+const (
+	closeNotifier = 1 << iota
+	flusher
+	hijacker
+	pusher
+	readerFrom
+)
+
+// pickDelegator is indexed by a bitmask of the optional interfaces the
+// wrapped http.ResponseWriter implements (see the constants above) and
+// returns a delegator whose static type implements exactly that set of
+// interfaces, so that a type assertion like w.(http.Flusher) behind
+// MeasuredHandler succeeds whenever the real ResponseWriter supports it.
+var pickDelegator = make([]func(*responseWriterDelegator) delegator, 32)
+
+func init() {
+	pickDelegator[0] = func(d *responseWriterDelegator) delegator { return d }
+	pickDelegator[closeNotifier] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+		}{
+			d,
+			closeNotifierDelegator{d},
+		}
+	}
+	pickDelegator[flusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+		}{
+			d,
+			flusherDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+		}
+	}
+	pickDelegator[hijacker] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Hijacker
+		}{
+			d,
+			hijackerDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+hijacker] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Hijacker
+		}{
+			d,
+			closeNotifierDelegator{d},
+			hijackerDelegator{d},
+		}
+	}
+	pickDelegator[flusher+hijacker] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+			http.Hijacker
+		}{
+			d,
+			flusherDelegator{d},
+			hijackerDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher+hijacker] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+			http.Hijacker
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+			hijackerDelegator{d},
+		}
+	}
+	pickDelegator[pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Pusher
+		}{
+			d,
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Pusher
+		}{
+			d,
+			closeNotifierDelegator{d},
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[flusher+pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+			http.Pusher
+		}{
+			d,
+			flusherDelegator{d},
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher+pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+			http.Pusher
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[hijacker+pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Hijacker
+			http.Pusher
+		}{
+			d,
+			hijackerDelegator{d},
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+hijacker+pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Hijacker
+			http.Pusher
+		}{
+			d,
+			closeNotifierDelegator{d},
+			hijackerDelegator{d},
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[flusher+hijacker+pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{
+			d,
+			flusherDelegator{d},
+			hijackerDelegator{d},
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher+hijacker+pusher] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+			hijackerDelegator{d},
+			pusherDelegator{d},
+		}
+	}
+	pickDelegator[readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			io.ReaderFrom
+		}{
+			d,
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[flusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+			io.ReaderFrom
+		}{
+			d,
+			flusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[hijacker+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Hijacker
+			io.ReaderFrom
+		}{
+			d,
+			hijackerDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+hijacker+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Hijacker
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			hijackerDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[flusher+hijacker+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{
+			d,
+			flusherDelegator{d},
+			hijackerDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher+hijacker+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+			hijackerDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[flusher+pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			flusherDelegator{d},
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher+pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[hijacker+pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			hijackerDelegator{d},
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+hijacker+pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			hijackerDelegator{d},
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[flusher+hijacker+pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			flusherDelegator{d},
+			hijackerDelegator{d},
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+	pickDelegator[closeNotifier+flusher+hijacker+pusher+readerFrom] = func(d *responseWriterDelegator) delegator {
+		return struct {
+			*responseWriterDelegator
+			http.CloseNotifier
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{
+			d,
+			closeNotifierDelegator{d},
+			flusherDelegator{d},
+			hijackerDelegator{d},
+			pusherDelegator{d},
+			readerFromDelegator{d},
+		}
+	}
+}
+
+// newDelegator wraps w in a responseWriterDelegator and returns a delegator
+// whose static type implements the same combination of http.Flusher,
+// http.Hijacker, http.CloseNotifier, http.Pusher and io.ReaderFrom that w
+// itself implements.
+func newDelegator(w http.ResponseWriter) delegator {
+	d := &responseWriterDelegator{ResponseWriter: w}
+
+	id := 0
+	//nolint:staticcheck // http.CloseNotifier is deprecated but still implemented by some ResponseWriters.
+	if _, ok := w.(http.CloseNotifier); ok {
+		id |= closeNotifier
+	}
+	if _, ok := w.(http.Flusher); ok {
+		id |= flusher
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		id |= hijacker
+	}
+	if _, ok := w.(http.Pusher); ok {
+		id |= pusher
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		id |= readerFrom
+	}
+
+	return pickDelegator[id](d)
+}
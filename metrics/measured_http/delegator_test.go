@@ -0,0 +1,75 @@
+package measured_http
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// flushHijackWriter is a minimal http.ResponseWriter that also implements
+// http.Flusher and http.Hijacker, mimicking the kind of ResponseWriter a real
+// net/http server hands handlers (httptest.ResponseRecorder implements
+// http.Flusher but not http.Hijacker, so it can't stand in for this test).
+type flushHijackWriter struct {
+	http.ResponseWriter
+	flushed bool
+}
+
+func (w *flushHijackWriter) Flush() {
+	w.flushed = true
+}
+
+func (w *flushHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+type testMux struct {
+	handler http.Handler
+	pattern string
+}
+
+func (m testMux) Handler(_ *http.Request) (http.Handler, string) {
+	return m.handler, m.pattern
+}
+
+// pathEchoMux simulates a custom mux, like the one used for ocsp-responder,
+// that can return arbitrary strings influenced by the request URL rather
+// than a pattern drawn from a small, fixed set of routes.
+type pathEchoMux struct {
+	handler http.Handler
+}
+
+func (m pathEchoMux) Handler(r *http.Request) (http.Handler, string) {
+	return m.handler, r.URL.Path
+}
+
+func TestMeasuredHandlerPreservesOptionalInterfaces(t *testing.T) {
+	var sawFlusher, sawHijacker bool
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); ok {
+			sawFlusher = true
+		}
+		if _, ok := w.(http.Hijacker); ok {
+			sawHijacker = true
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := newTestHandler(testMux{handler: inner, pattern: "/test"})
+
+	rec := httptest.NewRecorder()
+	w := &flushHijackWriter{ResponseWriter: rec}
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	h.ServeHTTP(w, req)
+
+	if !sawFlusher {
+		t.Error("handler behind MeasuredHandler could not type-assert its ResponseWriter to http.Flusher")
+	}
+	if !sawHijacker {
+		t.Error("handler behind MeasuredHandler could not type-assert its ResponseWriter to http.Hijacker")
+	}
+}
@@ -1,35 +1,22 @@
 package measured_http
 
 import (
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"sync"
 
 	"github.com/jmhodges/clock"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-// responseWriterWithStatus satisfies http.ResponseWriter, but keeps track of the
-// status code for gathering stats.
-type responseWriterWithStatus struct {
-	http.ResponseWriter
-	code int
-}
-
-// WriteHeader stores a status code for generating stats.
-func (r *responseWriterWithStatus) WriteHeader(code int) {
-	r.code = code
-	r.ResponseWriter.WriteHeader(code)
-}
-
-// Write writes the body and sets the status code to 200 if a status code
-// has not already been set.
-func (r *responseWriterWithStatus) Write(body []byte) (int, error) {
-	if r.code == 0 {
-		r.code = http.StatusOK
-	}
-	return r.ResponseWriter.Write(body)
-}
+// defaultSizeBuckets are used for both request_size_bytes and
+// response_size_bytes when New is not given an override. They span a few
+// hundred bytes (a bare ACME challenge response) to a few megabytes (a large
+// certificate chain or CRL), doubling each step.
+var defaultSizeBuckets = prometheus.ExponentialBuckets(256, 2, 15)
 
 // serveMux is a partial interface wrapper for the method http.ServeMux
 // exposes that we use. This is needed so that we can replace the default
@@ -48,9 +35,47 @@ type MeasuredHandler struct {
 	// inFlightRequestsGauge is a gauge that tracks the number of requests
 	// currently in flight, labeled by endpoint.
 	inFlightRequestsGauge *prometheus.GaugeVec
+	// requestSizeHist and responseSizeHist track the size, in bytes, of
+	// request bodies and response bodies, labeled by endpoint.
+	requestSizeHist  *prometheus.HistogramVec
+	responseSizeHist *prometheus.HistogramVec
+	// errorsCounter counts requests that ended in a 5xx status code or a
+	// panic in the sub-handler, labeled by endpoint.
+	errorsCounter *prometheus.CounterVec
+	// patterns is the allow-list of endpoint labels this handler will use
+	// verbatim; any other pattern returned by the wrapped serveMux is coerced
+	// to "unknown" so it can't balloon the cardinality of our metrics.
+	patterns map[string]struct{}
+	// logUnknownPatternOnce logs the first out-of-allow-list pattern seen and
+	// nothing thereafter. Logging per distinct pattern (rather than once per
+	// process) would just move the unbounded growth this handler exists to
+	// prevent from the Prometheus label space into an in-process map.
+	logUnknownPatternOnce sync.Once
+}
+
+// countingReadCloser wraps an io.ReadCloser, counting the bytes read from it
+// so the size of a request body can be measured even when Content-Length
+// isn't set (e.g. chunked transfer encoding).
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
 }
 
-func New(m serveMux, clk clock.Clock, stats prometheus.Registerer, opts ...otelhttp.Option) http.Handler {
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// New constructs a MeasuredHandler. patterns is the complete set of mux
+// patterns m.Handler may return for a matched route; any other pattern
+// (e.g. the empty string or a raw path returned for an unmatched request)
+// is labeled "unknown" instead of being used as-is, so that requests to
+// made-up paths can't create unbounded Prometheus timeseries.
+// requestSizeBuckets and responseSizeBuckets override the default
+// histogram buckets used for the request_size_bytes and response_size_bytes
+// metrics; pass nil for either to use defaultSizeBuckets.
+func New(m serveMux, patterns []string, clk clock.Clock, stats prometheus.Registerer, requestSizeBuckets, responseSizeBuckets []float64, opts ...otelhttp.Option) http.Handler {
 	responseTime := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name: "response_time",
@@ -68,19 +93,82 @@ func New(m serveMux, clk clock.Clock, stats prometheus.Registerer, opts ...otelh
 	)
 	stats.MustRegister(inFlightRequestsGauge)
 
+	if requestSizeBuckets == nil {
+		requestSizeBuckets = defaultSizeBuckets
+	}
+	requestSizeHist := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "request_size_bytes",
+			Help:    "Size of request bodies, labeled by endpoint.",
+			Buckets: requestSizeBuckets,
+		},
+		[]string{"endpoint"})
+	stats.MustRegister(requestSizeHist)
+
+	if responseSizeBuckets == nil {
+		responseSizeBuckets = defaultSizeBuckets
+	}
+	responseSizeHist := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "response_size_bytes",
+			Help:    "Size of response bodies, labeled by endpoint.",
+			Buckets: responseSizeBuckets,
+		},
+		[]string{"endpoint"})
+	stats.MustRegister(responseSizeHist)
+
+	errorsCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "request_errors_total",
+			Help: "Count of requests that returned a 5xx status code or panicked, labeled by endpoint.",
+		},
+		[]string{"endpoint"})
+	stats.MustRegister(errorsCounter)
+
+	patternSet := make(map[string]struct{}, len(patterns))
+	for _, p := range patterns {
+		patternSet[p] = struct{}{}
+	}
+
 	return otelhttp.NewHandler(&MeasuredHandler{
 		serveMux:              m,
 		clk:                   clk,
 		stat:                  responseTime,
 		inFlightRequestsGauge: inFlightRequestsGauge,
+		requestSizeHist:       requestSizeHist,
+		responseSizeHist:      responseSizeHist,
+		errorsCounter:         errorsCounter,
+		patterns:              patternSet,
 	}, "server", opts...)
 }
 
+// endpointLabel returns pattern unchanged if it's in the handler's registered
+// allow-list, and "unknown" otherwise. Only the first unrecognized pattern
+// seen by this handler is logged, to avoid keeping per-pattern bookkeeping
+// that would itself grow without bound under the same adversarial traffic
+// this method exists to defend against.
+func (h *MeasuredHandler) endpointLabel(pattern string) string {
+	if _, ok := h.patterns[pattern]; ok {
+		return pattern
+	}
+
+	h.logUnknownPatternOnce.Do(func() {
+		log.Printf("measured_http: endpoint pattern %q is not in the registered set, labeling as \"unknown\" (further unrecognized patterns will not be logged individually)", pattern)
+	})
+
+	return "unknown"
+}
+
 func (h *MeasuredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	begin := h.clk.Now()
-	rwws := &responseWriterWithStatus{w, 0}
+	// newDelegator picks a wrapper type that implements exactly the set of
+	// optional interfaces (http.Flusher, http.Hijacker, etc) that w itself
+	// implements, so handlers behind MeasuredHandler can still type-assert
+	// their way to them.
+	rwws := newDelegator(w)
 
-	subHandler, pattern := h.Handler(r)
+	subHandler, rawPattern := h.Handler(r)
+	pattern := h.endpointLabel(rawPattern)
 	h.inFlightRequestsGauge.WithLabelValues(pattern).Inc()
 	defer h.inFlightRequestsGauge.WithLabelValues(pattern).Dec()
 
@@ -96,12 +184,37 @@ func (h *MeasuredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		method = "unknown"
 	}
 
+	// If Content-Length wasn't provided by the client, count the bytes read
+	// from the body as the sub-handler consumes it.
+	var bodyCounter *countingReadCloser
+	reqSize := float64(r.ContentLength)
+	if r.ContentLength < 0 && r.Body != nil {
+		bodyCounter = &countingReadCloser{ReadCloser: r.Body}
+		r.Body = bodyCounter
+	}
+
 	defer func() {
+		panicked := recover()
+
+		if bodyCounter != nil {
+			reqSize = float64(bodyCounter.n)
+		}
+		h.requestSizeHist.WithLabelValues(pattern).Observe(reqSize)
+		h.responseSizeHist.WithLabelValues(pattern).Observe(float64(rwws.Size()))
+
+		if panicked != nil || rwws.Status() >= 500 {
+			h.errorsCounter.WithLabelValues(pattern).Inc()
+		}
+
 		h.stat.With(prometheus.Labels{
 			"endpoint": pattern,
 			"method":   method,
-			"code":     strconv.Itoa(rwws.code),
+			"code":     strconv.Itoa(rwws.Status()),
 		}).Observe(h.clk.Since(begin).Seconds())
+
+		if panicked != nil {
+			panic(panicked)
+		}
 	}()
 
 	subHandler.ServeHTTP(rwws, r)
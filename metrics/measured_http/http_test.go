@@ -0,0 +1,106 @@
+package measured_http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestHandler builds a MeasuredHandler wired up to its own, unregistered
+// metric vectors, bypassing New's otelhttp wrapping so tests can call ServeHTTP
+// directly and inspect the resulting metrics.
+func newTestHandler(m serveMux) *MeasuredHandler {
+	return &MeasuredHandler{
+		serveMux: m,
+		clk:      clock.NewFake(),
+		stat: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "test_response_time"},
+			[]string{"endpoint", "method", "code"}),
+		inFlightRequestsGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{Name: "test_in_flight_requests"},
+			[]string{"endpoint"}),
+		requestSizeHist: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "test_request_size_bytes", Buckets: defaultSizeBuckets},
+			[]string{"endpoint"}),
+		responseSizeHist: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "test_response_size_bytes", Buckets: defaultSizeBuckets},
+			[]string{"endpoint"}),
+		errorsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "test_request_errors_total"},
+			[]string{"endpoint"}),
+		patterns: map[string]struct{}{"/test": {}},
+	}
+}
+
+func TestMeasuredHandlerRecordsRequestAndResponseSize(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte("hello world"))
+	})
+
+	h := newTestHandler(testMux{handler: inner, pattern: "/test"})
+
+	req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("a request body"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.CollectAndCount(h.requestSizeHist); got != 1 {
+		t.Errorf("request_size_bytes series count = %d, want 1", got)
+	}
+	if got := testutil.CollectAndCount(h.responseSizeHist); got != 1 {
+		t.Errorf("response_size_bytes series count = %d, want 1", got)
+	}
+}
+
+func TestMeasuredHandlerCountsServerErrors(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	h := newTestHandler(testMux{handler: inner, pattern: "/test"})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	if got := testutil.ToFloat64(h.errorsCounter.WithLabelValues("/test")); got != 1 {
+		t.Errorf("request_errors_total = %v, want 1", got)
+	}
+}
+
+func TestMeasuredHandlerCountsPanicsAsErrorsAndRepanics(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	h := newTestHandler(testMux{handler: inner, pattern: "/test"})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("ServeHTTP swallowed the sub-handler's panic instead of re-panicking")
+		}
+		if got := testutil.ToFloat64(h.errorsCounter.WithLabelValues("/test")); got != 1 {
+			t.Errorf("request_errors_total = %v, want 1", got)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+}
+
+func TestMeasuredHandlerBoundsUnregisteredPatternCardinality(t *testing.T) {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	h := newTestHandler(pathEchoMux{handler: inner})
+
+	paths := []string{"/nonexistent/a", "/nonexistent/b", "/another-bogus-path"}
+	for _, path := range paths {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := testutil.CollectAndCount(h.requestSizeHist); got != 1 {
+		t.Errorf("request_size_bytes has %d timeseries after requests to %d distinct unmapped paths, want them coalesced onto a single \"unknown\" series", got, len(paths))
+	}
+}
@@ -0,0 +1,185 @@
+package ratelimits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/redis/go-redis/v9"
+)
+
+// spendScript implements the same GCRA recurrence as gcra, but atomically on
+// the Redis server so that two WFEs spending from the same bucket at once
+// can't race a Get with a Set. It computes the new TAT, rejects the request
+// if that TAT would exceed burstOffset in the future, and - only when the
+// request is allowed - stores the new TAT with a PEXPIRE set to exactly the
+// time it'll take the bucket to drain back to empty, so spent buckets don't
+// linger in Redis once they stop being rate limited.
+//
+// KEYS[1] is the bucket key. ARGV is, in order: the current time, the
+// request cost, the emission interval, and the burst offset, all as
+// millisecond integers. The reply is a 3-element array: 1 or 0 for allowed,
+// the TAT in force after this call (milliseconds), and the number of
+// milliseconds the caller should wait before retrying (0 if allowed).
+var spendScript = redis.NewScript(`
+local tat = tonumber(redis.call('GET', KEYS[1]))
+local now = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local emission_interval = tonumber(ARGV[3])
+local burst_offset = tonumber(ARGV[4])
+
+if tat == nil or tat < now then
+	tat = now
+end
+
+local new_tat = tat + (cost * emission_interval)
+local allow_at = new_tat - burst_offset
+
+if allow_at > now then
+	return {0, tat, allow_at - now}
+end
+
+local ttl = new_tat - now
+if ttl > 0 then
+	redis.call('SET', KEYS[1], new_tat, 'PX', ttl)
+end
+return {1, new_tat, 0}
+`)
+
+// redisSource is a Redis-backed implementation of the source interface. It
+// stores TATs keyed by bucketKey so that rate limit state survives WFE
+// restarts and is shared across every WFE process hitting the same Redis.
+type redisSource struct {
+	client redis.UniversalClient
+	clk    clock.Clock
+}
+
+// newRedisSource constructs a redisSource. client is expected to be shared
+// by every process enforcing the same limits; a *redis.ClusterClient and a
+// *redis.Ring both satisfy redis.UniversalClient.
+func newRedisSource(client redis.UniversalClient, clk clock.Clock) *redisSource {
+	return &redisSource{client: client, clk: clk}
+}
+
+// shardedKey wraps bucketKey in a hashtag so that every Redis operation for
+// a given bucket - today just a single GET/SET/DEL/EVAL key, but potentially
+// more in the future - hashes to the same cluster slot.
+func shardedKey(bucketKey string) string {
+	return "{" + bucketKey + "}"
+}
+
+// ttlUntil returns the duration from now until tat, the same quantity
+// spendScript passes as its PX argument, floored at 1ms so a tat that's
+// already in the past still expires promptly instead of being stored with
+// no expiry at all.
+func ttlUntil(now, tat time.Time) time.Duration {
+	ttl := tat.Sub(now)
+	if ttl <= 0 {
+		ttl = time.Millisecond
+	}
+	return ttl
+}
+
+// Set and Get store the TAT as Unix milliseconds, matching the precision
+// spendScript uses, so a TAT written by one code path round-trips correctly
+// through the other. Set expires the key the same way spendScript does, so a
+// bucket written via Set self-evicts once it drains instead of persisting in
+// Redis forever.
+func (r *redisSource) Set(ctx context.Context, bucketKey string, tat time.Time) error {
+	ttl := ttlUntil(r.clk.Now(), tat)
+	err := r.client.Set(ctx, shardedKey(bucketKey), tat.UnixMilli(), ttl).Err()
+	if err != nil {
+		return fmt.Errorf("setting TAT for bucket %q: %w", bucketKey, err)
+	}
+	return nil
+}
+
+func (r *redisSource) Get(ctx context.Context, bucketKey string) (time.Time, error) {
+	tatMillis, err := r.client.Get(ctx, shardedKey(bucketKey)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, ErrBucketNotFound
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("getting TAT for bucket %q: %w", bucketKey, err)
+	}
+	return time.UnixMilli(tatMillis), nil
+}
+
+// BatchGet fetches every bucketKey in a single round trip, rather than the
+// sequential round trips a caller checking several limits (per-account,
+// per-registration, per-domain, per-IP) would otherwise need. It pipelines
+// one GET per bucketKey instead of issuing a single multi-key MGET, because
+// shardedKey hashtags each bucketKey independently: distinct buckets land on
+// distinct slots on a *redis.ClusterClient, and a multi-key command spanning
+// more than one slot fails with CROSSSLOT. A pipeline of single-key commands
+// still costs one round trip and works regardless of how the keys are
+// sharded.
+func (r *redisSource) BatchGet(ctx context.Context, bucketKeys []string) (map[string]time.Time, error) {
+	pipe := r.client.Pipeline()
+	cmds := make(map[string]*redis.StringCmd, len(bucketKeys))
+	for _, bucketKey := range bucketKeys {
+		cmds[bucketKey] = pipe.Get(ctx, shardedKey(bucketKey))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("batch getting %d buckets: %w", len(bucketKeys), err)
+	}
+
+	tats := make(map[string]time.Time, len(bucketKeys))
+	for bucketKey, cmd := range cmds {
+		tatMillis, err := cmd.Int64()
+		if errors.Is(err, redis.Nil) {
+			// This bucketKey has no TAT yet.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("getting TAT for bucket %q: %w", bucketKey, err)
+		}
+		tats[bucketKey] = time.UnixMilli(tatMillis)
+	}
+	return tats, nil
+}
+
+// BatchSet writes every TAT in tats in a single round trip, pipelining one
+// SET per bucketKey - for the same cross-slot reason BatchGet does - each
+// with the same TTL treatment as Set, so buckets written through the batch
+// path self-evict too.
+func (r *redisSource) BatchSet(ctx context.Context, tats map[string]time.Time) error {
+	now := r.clk.Now()
+	pipe := r.client.Pipeline()
+	for bucketKey, tat := range tats {
+		pipe.Set(ctx, shardedKey(bucketKey), tat.UnixMilli(), ttlUntil(now, tat))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("batch setting %d buckets: %w", len(tats), err)
+	}
+	return nil
+}
+
+func (r *redisSource) Delete(ctx context.Context, bucketKey string) error {
+	err := r.client.Del(ctx, shardedKey(bucketKey)).Err()
+	if err != nil {
+		return fmt.Errorf("deleting bucket %q: %w", bucketKey, err)
+	}
+	return nil
+}
+
+func (r *redisSource) Spend(ctx context.Context, bucketKey string, cost int64, emissionInterval, burstOffset time.Duration) (bool, time.Time, time.Duration, error) {
+	now := r.clk.Now()
+	res, err := spendScript.Run(ctx, r.client, []string{shardedKey(bucketKey)},
+		now.UnixMilli(),
+		cost,
+		emissionInterval.Milliseconds(),
+		burstOffset.Milliseconds(),
+	).Slice()
+	if err != nil {
+		return false, time.Time{}, 0, fmt.Errorf("spending from bucket %q: %w", bucketKey, err)
+	}
+	if len(res) != 3 {
+		return false, time.Time{}, 0, fmt.Errorf("spending from bucket %q: unexpected script reply %v", bucketKey, res)
+	}
+
+	allowed := res[0].(int64) == 1
+	newTAT := time.UnixMilli(res[1].(int64))
+	retryAfter := time.Duration(res[2].(int64)) * time.Millisecond
+	return allowed, newTAT, retryAfter, nil
+}
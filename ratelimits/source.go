@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/jmhodges/clock"
 )
 
 // ErrBucketNotFound indicates that the bucket was not found.
@@ -20,17 +22,53 @@ type source interface {
 
 	// Delete deletes the TAT at the specified bucketKey ('name:id').
 	Delete(ctx context.Context, bucketKey string) error
+
+	// Spend atomically applies a GCRA update to the bucket at bucketKey:
+	// it advances the TAT by cost*emissionInterval, allowing the request
+	// only if the resulting TAT is no more than burstOffset in the future,
+	// and persists the new TAT only when the request is allowed. It's the
+	// concurrency-safe alternative to a Get followed by a Set, since that
+	// pairing races when two requests spend from the same bucket at once.
+	Spend(ctx context.Context, bucketKey string, cost int64, emissionInterval, burstOffset time.Duration) (allowed bool, newTAT time.Time, retryAfter time.Duration, err error)
+
+	// BatchGet retrieves the TATs for each of the given bucketKeys in a
+	// single round trip. A bucketKey with no bucket yet (the equivalent of
+	// Get returning ErrBucketNotFound) is simply omitted from the result,
+	// rather than failing the whole batch.
+	BatchGet(ctx context.Context, bucketKeys []string) (map[string]time.Time, error)
+
+	// BatchSet stores the given TATs, keyed by bucketKey, in a single round
+	// trip.
+	BatchSet(ctx context.Context, tats map[string]time.Time) error
+}
+
+// gcra computes the Generic Cell Rate Algorithm recurrence shared by every
+// source implementation: given the bucket's current TAT, it returns whether
+// a request of the given cost is allowed, the TAT that should be persisted,
+// and, if not allowed, how long the caller should wait before retrying.
+func gcra(now, tat time.Time, cost int64, emissionInterval, burstOffset time.Duration) (allowed bool, newTAT time.Time, retryAfter time.Duration) {
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTAT = tat.Add(time.Duration(cost) * emissionInterval)
+	allowedAt := newTAT.Add(-burstOffset)
+	if allowedAt.After(now) {
+		return false, tat, allowedAt.Sub(now)
+	}
+	return true, newTAT, 0
 }
 
 // inmem is an in-memory implementation of the source interface used for
 // testing.
 type inmem struct {
 	sync.RWMutex
-	m map[string]time.Time
+	clk clock.Clock
+	m   map[string]time.Time
 }
 
-func newInmem() *inmem {
-	return &inmem{m: make(map[string]time.Time)}
+func newInmem(clk clock.Clock) *inmem {
+	return &inmem{clk: clk, m: make(map[string]time.Time)}
 }
 
 func (in *inmem) Set(_ context.Context, bucketKey string, tat time.Time) error {
@@ -56,3 +94,36 @@ func (in *inmem) Delete(_ context.Context, bucketKey string) error {
 	delete(in.m, bucketKey)
 	return nil
 }
+
+func (in *inmem) Spend(_ context.Context, bucketKey string, cost int64, emissionInterval, burstOffset time.Duration) (bool, time.Time, time.Duration, error) {
+	in.Lock()
+	defer in.Unlock()
+
+	now := in.clk.Now()
+	allowed, newTAT, retryAfter := gcra(now, in.m[bucketKey], cost, emissionInterval, burstOffset)
+	if allowed {
+		in.m[bucketKey] = newTAT
+	}
+	return allowed, newTAT, retryAfter, nil
+}
+
+func (in *inmem) BatchGet(_ context.Context, bucketKeys []string) (map[string]time.Time, error) {
+	in.RLock()
+	defer in.RUnlock()
+	tats := make(map[string]time.Time, len(bucketKeys))
+	for _, bucketKey := range bucketKeys {
+		if tat, ok := in.m[bucketKey]; ok {
+			tats[bucketKey] = tat
+		}
+	}
+	return tats, nil
+}
+
+func (in *inmem) BatchSet(_ context.Context, tats map[string]time.Time) error {
+	in.Lock()
+	defer in.Unlock()
+	for bucketKey, tat := range tats {
+		in.m[bucketKey] = tat
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package ratelimits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jmhodges/clock"
+	"github.com/redis/go-redis/v9"
+)
+
+// testSources returns an inmem and a miniredis-backed redisSource sharing
+// the same fake clock, so test scenarios can be run against both and held
+// to identical behavior.
+func testSources(t *testing.T, clk clock.Clock) map[string]source {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	return map[string]source{
+		"inmem": newInmem(clk),
+		"redis": newRedisSource(redisClient, clk),
+	}
+}
+
+// TestSpend exercises the GCRA recurrence against both source
+// implementations with the same fake clock, so the two are held to
+// identical behavior: a bucket with burst 2 and an emission interval of one
+// second should allow two immediate requests, deny a third, and then allow
+// again once the clock advances by one emission interval.
+func TestSpend(t *testing.T) {
+	clk := clock.NewFake()
+	sources := testSources(t, clk)
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			const bucketKey = "test:spend"
+			const emissionInterval = time.Second
+			const burstOffset = 2 * time.Second
+
+			allowed, _, _, err := src.Spend(ctx, bucketKey, 1, emissionInterval, burstOffset)
+			if err != nil {
+				t.Fatalf("Spend: %s", err)
+			}
+			if !allowed {
+				t.Error("first request into an empty bucket was denied, want allowed")
+			}
+
+			allowed, _, _, err = src.Spend(ctx, bucketKey, 1, emissionInterval, burstOffset)
+			if err != nil {
+				t.Fatalf("Spend: %s", err)
+			}
+			if !allowed {
+				t.Error("second request within burst capacity was denied, want allowed")
+			}
+
+			allowed, _, retryAfter, err := src.Spend(ctx, bucketKey, 1, emissionInterval, burstOffset)
+			if err != nil {
+				t.Fatalf("Spend: %s", err)
+			}
+			if allowed {
+				t.Error("third request beyond burst capacity was allowed, want denied")
+			}
+			if retryAfter != emissionInterval {
+				t.Errorf("retryAfter = %s, want %s", retryAfter, emissionInterval)
+			}
+
+			clk.Add(emissionInterval)
+			allowed, _, _, err = src.Spend(ctx, bucketKey, 1, emissionInterval, burstOffset)
+			if err != nil {
+				t.Fatalf("Spend: %s", err)
+			}
+			if !allowed {
+				t.Error("request one emission interval later was denied, want allowed")
+			}
+		})
+	}
+}
+
+// TestSpendThenGet checks that a TAT persisted by Spend is visible via Get,
+// for both source implementations.
+func TestSpendThenGet(t *testing.T) {
+	clk := clock.NewFake()
+	sources := testSources(t, clk)
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			const bucketKey = "test:spend-then-get"
+
+			_, err := src.Get(ctx, bucketKey)
+			if err != ErrBucketNotFound {
+				t.Fatalf("Get on an unspent bucket returned err %v, want ErrBucketNotFound", err)
+			}
+
+			_, wantTAT, _, err := src.Spend(ctx, bucketKey, 1, time.Second, 2*time.Second)
+			if err != nil {
+				t.Fatalf("Spend: %s", err)
+			}
+
+			gotTAT, err := src.Get(ctx, bucketKey)
+			if err != nil {
+				t.Fatalf("Get: %s", err)
+			}
+			if !gotTAT.Equal(wantTAT) {
+				t.Errorf("Get returned TAT %s, want %s", gotTAT, wantTAT)
+			}
+		})
+	}
+}
+
+// TestBatchGetAndBatchSet checks that BatchSet followed by BatchGet
+// round-trips every bucket's TAT in one shot, and that a bucketKey with no
+// stored TAT is simply absent from the result rather than causing an error.
+func TestBatchGetAndBatchSet(t *testing.T) {
+	clk := clock.NewFake()
+	sources := testSources(t, clk)
+
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			now := clk.Now()
+			tats := map[string]time.Time{
+				"test:batch-a": now.Add(1 * time.Second),
+				"test:batch-b": now.Add(2 * time.Second),
+			}
+
+			err := src.BatchSet(ctx, tats)
+			if err != nil {
+				t.Fatalf("BatchSet: %s", err)
+			}
+
+			got, err := src.BatchGet(ctx, []string{"test:batch-a", "test:batch-b", "test:batch-missing"})
+			if err != nil {
+				t.Fatalf("BatchGet: %s", err)
+			}
+
+			if len(got) != len(tats) {
+				t.Fatalf("BatchGet returned %d entries, want %d: %v", len(got), len(tats), got)
+			}
+			for bucketKey, wantTAT := range tats {
+				gotTAT, ok := got[bucketKey]
+				if !ok {
+					t.Errorf("BatchGet result missing bucket %q", bucketKey)
+					continue
+				}
+				if !gotTAT.Equal(wantTAT) {
+					t.Errorf("BatchGet[%q] = %s, want %s", bucketKey, gotTAT, wantTAT)
+				}
+			}
+			if _, ok := got["test:batch-missing"]; ok {
+				t.Error("BatchGet returned an entry for a bucketKey that was never set")
+			}
+		})
+	}
+}